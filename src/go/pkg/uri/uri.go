@@ -0,0 +1,711 @@
+/*
+** Zabbix
+** Copyright (C) 2001-2023 Zabbix SIA
+**
+** This program is free software; you can redistribute it and/or modify
+** it under the terms of the GNU General Public License as published by
+** the Free Software Foundation; either version 2 of the License, or
+** (at your option) any later version.
+**
+** This program is distributed in the hope that it will be useful,
+** but WITHOUT ANY WARRANTY; without even the implied warranty of
+** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+** GNU General Public License for more details.
+**
+** You should have received a copy of the GNU General Public License
+** along with this program; if not, write to the Free Software
+** Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+**/
+
+// Package uri parses and validates the resource identifiers plugins use to
+// describe where to connect to (host[:port], unix sockets, database and
+// agent URIs).
+package uri
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+const maxPort = 65535
+
+// defaultScheme is used when rawuri has no scheme and Defaults does not specify one either.
+const defaultScheme = "tcp"
+
+var (
+	schemeRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.\-]*$`)
+	hostRegex   = regexp.MustCompile(`^[A-Za-z0-9.\-_~:%]+$`)
+)
+
+// URI allows to parse a string as a resource identifier. All parts besides
+// scheme are optional.
+type URI struct {
+	scheme    string
+	host      string
+	port      string
+	resource  string
+	socket    string
+	user      string
+	password  string
+	rawQuery  string
+	fragment  string
+	query     url.Values
+	endpoints []Endpoint
+}
+
+// Endpoint is a single host+port pair within a possibly comma-separated list
+// of hosts, as used by database drivers that support failover/replica sets.
+type Endpoint struct {
+	Host string
+	Port string
+}
+
+// Defaults specify scheme and port returned by New() when rawuri does not specify them.
+type Defaults struct {
+	Scheme string
+	Port   string
+}
+
+// PathStyle controls whether and how New() accepts a resource/path component for a scheme.
+type PathStyle int
+
+const (
+	// PathStyleNone rejects a resource/path component.
+	PathStyleNone PathStyle = iota
+	// PathStyleResource accepts a single path segment, e.g. a database name.
+	PathStyleResource
+	// PathStyleFull accepts an arbitrary, possibly nested, path.
+	PathStyleFull
+)
+
+// SchemeSpec describes how New() and URIValidator handle a particular scheme.
+type SchemeSpec struct {
+	// DefaultPort is used when neither rawuri nor Defaults specify a port.
+	DefaultPort string
+	// RequiresHost makes New() fail when the scheme is used without a host.
+	RequiresHost bool
+	// AllowsSocket lets "scheme:///path" be parsed as a unix socket path.
+	AllowsSocket bool
+	PathStyle    PathStyle
+	// Validate, if set, is called on the parsed URI before New() returns it. It
+	// is not called for a URI parsed as a unix socket path.
+	Validate func(*URI) error
+}
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = make(map[string]SchemeSpec)
+)
+
+// RegisterScheme registers spec for the given scheme name, overriding any previous
+// registration. It is typically called from plugin init() functions.
+func RegisterScheme(name string, spec SchemeSpec) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+
+	schemes[name] = spec
+}
+
+func lookupScheme(name string) (SchemeSpec, bool) {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+
+	spec, ok := schemes[name]
+
+	return spec, ok
+}
+
+func init() {
+	RegisterScheme("tcp", SchemeSpec{RequiresHost: true, PathStyle: PathStyleResource})
+	RegisterScheme("unix", SchemeSpec{AllowsSocket: true})
+	RegisterScheme("http", SchemeSpec{DefaultPort: "80", RequiresHost: true, PathStyle: PathStyleFull})
+	RegisterScheme("https", SchemeSpec{DefaultPort: "443", RequiresHost: true, PathStyle: PathStyleFull})
+	RegisterScheme("oracle", SchemeSpec{DefaultPort: "1521", RequiresHost: true, PathStyle: PathStyleResource})
+	RegisterScheme("postgres", SchemeSpec{
+		DefaultPort: "5432", RequiresHost: true, AllowsSocket: true, PathStyle: PathStyleResource,
+	})
+	RegisterScheme("mysql", SchemeSpec{
+		DefaultPort: "3306", RequiresHost: true, AllowsSocket: true, PathStyle: PathStyleResource,
+	})
+	RegisterScheme("mongodb", SchemeSpec{DefaultPort: "27017", RequiresHost: true, PathStyle: PathStyleResource})
+	RegisterScheme("redis", SchemeSpec{
+		DefaultPort: "6379", RequiresHost: true, AllowsSocket: true, PathStyle: PathStyleResource,
+	})
+	RegisterScheme("memcached", SchemeSpec{
+		DefaultPort: "11211", RequiresHost: true, AllowsSocket: true, PathStyle: PathStyleResource,
+	})
+	RegisterScheme("mongodb+srv", SchemeSpec{
+		RequiresHost: true, PathStyle: PathStyleResource, Validate: rejectExplicitPorts,
+	})
+	RegisterScheme("redis+sentinel", SchemeSpec{
+		DefaultPort: "26379", RequiresHost: true, PathStyle: PathStyleResource,
+	})
+}
+
+// rejectExplicitPorts is used by schemes such as mongodb+srv where the port is
+// resolved via DNS SRV records and must not be given explicitly.
+func rejectExplicitPorts(u *URI) error {
+	for _, e := range u.Endpoints() {
+		if e.Port != "" {
+			return fmt.Errorf("cannot parse URI: scheme %q does not accept a port", u.scheme)
+		}
+	}
+
+	return nil
+}
+
+// New parses rawuri and returns a populated URI. Defaults are applied for
+// scheme/port when rawuri does not specify them explicitly. New returns an
+// error if rawuri or defaults cannot be parsed.
+func New(rawuri string, defaults *Defaults) (res *URI, err error) {
+	if err = validateDefaults(defaults); err != nil {
+		return nil, err
+	}
+
+	rawuri, fragment := cutFragment(rawuri)
+	rawuri, rawQuery := cutQuery(rawuri)
+
+	query, err := parseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, rest, schemeSet := cutScheme(rawuri)
+	if schemeSet && scheme == "" {
+		return nil, fmt.Errorf("cannot parse URI: scheme is missing")
+	}
+	if scheme != "" && !schemeRegex.MatchString(scheme) {
+		return nil, fmt.Errorf("cannot parse URI: invalid scheme %q", scheme)
+	}
+
+	u := &URI{scheme: scheme, rawQuery: rawQuery, fragment: fragment, query: query}
+
+	if scheme == "" && strings.HasPrefix(rawuri, "/") {
+		u.scheme = "unix"
+		u.socket = rawuri
+
+		return u, nil
+	}
+
+	authority, resource := cutResource(rest)
+
+	if scheme != "" {
+		if spec, ok := lookupScheme(scheme); ok && spec.AllowsSocket && authority == "" {
+			if resource == "" {
+				return nil, fmt.Errorf("cannot parse URI: socket path is missing")
+			}
+
+			u.socket = "/" + resource
+
+			return u, nil
+		}
+	}
+
+	if u.scheme == "" {
+		if defaults != nil && defaults.Scheme != "" {
+			u.scheme = defaults.Scheme
+		} else {
+			u.scheme = defaultScheme
+		}
+	}
+
+	spec, ok := lookupScheme(u.scheme)
+	if !ok {
+		spec = SchemeSpec{RequiresHost: true, PathStyle: PathStyleResource}
+	}
+
+	user, password, authority := cutUserinfo(authority)
+
+	user, err = url.PathUnescape(user)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URI: invalid user %q", user)
+	}
+
+	password, err = url.PathUnescape(password)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URI: invalid password")
+	}
+
+	resource, err = url.PathUnescape(resource)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URI: invalid resource %q", resource)
+	}
+
+	endpoints, err := parseEndpoints(authority, defaults, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.RequiresHost {
+		for _, e := range endpoints {
+			if e.Host == "" {
+				return nil, fmt.Errorf("cannot parse URI: host is missing")
+			}
+		}
+	}
+
+	switch spec.PathStyle {
+	case PathStyleNone:
+		if resource != "" {
+			return nil, fmt.Errorf("cannot parse URI: scheme %q does not accept a resource", u.scheme)
+		}
+	case PathStyleResource:
+		if strings.Contains(resource, "/") {
+			return nil, fmt.Errorf("cannot parse URI: resource %q must be a single segment", resource)
+		}
+	}
+
+	u.host = endpoints[0].Host
+	u.port = endpoints[0].Port
+	if len(endpoints) > 1 {
+		u.endpoints = endpoints
+	}
+	u.resource = resource
+	u.user = user
+	u.password = password
+
+	if spec.Validate != nil {
+		if err = spec.Validate(u); err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// cutScheme splits rawuri into scheme and the remainder following "://", if present.
+func cutScheme(rawuri string) (scheme, rest string, schemeSet bool) {
+	if i := strings.Index(rawuri, "://"); i != -1 {
+		return rawuri[:i], rawuri[i+3:], true
+	}
+
+	return "", rawuri, false
+}
+
+// cutFragment splits off a trailing "#fragment", if present.
+func cutFragment(rawuri string) (rest, fragment string) {
+	if i := strings.Index(rawuri, "#"); i != -1 {
+		return rawuri[:i], rawuri[i+1:]
+	}
+
+	return rawuri, ""
+}
+
+// cutQuery splits off a trailing "?query", if present.
+func cutQuery(rawuri string) (rest, rawQuery string) {
+	if i := strings.Index(rawuri, "?"); i != -1 {
+		return rawuri[:i], rawuri[i+1:]
+	}
+
+	return rawuri, ""
+}
+
+func parseQuery(rawQuery string) (url.Values, error) {
+	if rawQuery == "" {
+		return nil, nil
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URI: invalid query %q", rawQuery)
+	}
+
+	return query, nil
+}
+
+// cutResource splits rest into the authority and the resource (path, without the leading slash).
+func cutResource(rest string) (authority, resource string) {
+	if i := strings.Index(rest, "/"); i != -1 {
+		return rest[:i], rest[i+1:]
+	}
+
+	return rest, ""
+}
+
+// cutUserinfo splits off a leading "user[:password]@" from authority.
+func cutUserinfo(authority string) (user, password, rest string) {
+	i := strings.LastIndex(authority, "@")
+	if i == -1 {
+		return "", "", authority
+	}
+
+	userinfo := authority[:i]
+	rest = authority[i+1:]
+
+	if j := strings.Index(userinfo, ":"); j != -1 {
+		return userinfo[:j], userinfo[j+1:], rest
+	}
+
+	return userinfo, "", rest
+}
+
+// splitHostPort splits authority into host and port, decoding a bracketed
+// IPv6 address (with an optional zone id) the way net/url does. bracketed
+// reports whether the host was given in "[...]" form, i.e. is an IPv6 literal.
+func splitHostPort(authority string) (host, port string, bracketed bool, err error) {
+	if strings.HasPrefix(authority, "[") {
+		i := strings.Index(authority, "]")
+		if i == -1 {
+			return "", "", false, fmt.Errorf("cannot parse URI: unmatched '[' in host")
+		}
+
+		host = strings.ReplaceAll(authority[1:i], "%25", "%")
+		rest := authority[i+1:]
+
+		if strings.HasPrefix(rest, ":") {
+			port = rest[1:]
+		}
+
+		return host, port, true, nil
+	}
+
+	if i := strings.LastIndex(authority, ":"); i != -1 {
+		return authority[:i], authority[i+1:], false, nil
+	}
+
+	return authority, "", false, nil
+}
+
+// parseEndpoints splits authority on "," into one or more host[:port] entries,
+// normalizing and validating each one the same way New() does for a single host.
+func parseEndpoints(authority string, defaults *Defaults, spec SchemeSpec) ([]Endpoint, error) {
+	parts := strings.Split(authority, ",")
+	endpoints := make([]Endpoint, 0, len(parts))
+
+	for _, part := range parts {
+		host, port, bracketed, err := splitHostPort(part)
+		if err != nil {
+			return nil, err
+		}
+
+		if host != "" {
+			if !bracketed && net.ParseIP(host) == nil && !isASCII(host) {
+				if host, err = idna.Lookup.ToASCII(host); err != nil {
+					return nil, fmt.Errorf("cannot parse URI: invalid host: %s", err)
+				}
+			}
+
+			if !hostRegex.MatchString(host) {
+				return nil, fmt.Errorf("cannot parse URI: invalid host %q", host)
+			}
+		}
+
+		if port == "" {
+			if defaults != nil && defaults.Port != "" {
+				port = defaults.Port
+			} else {
+				port = spec.DefaultPort
+			}
+		} else if err = validatePort(port); err != nil {
+			return nil, err
+		}
+
+		endpoints = append(endpoints, Endpoint{Host: host, Port: port})
+	}
+
+	return endpoints, nil
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateDefaults(defaults *Defaults) error {
+	if defaults == nil || defaults.Port == "" {
+		return nil
+	}
+
+	return validatePort(defaults.Port)
+}
+
+func validatePort(port string) error {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("cannot parse URI: invalid port %q", port)
+	}
+
+	if p < 1 || p > maxPort {
+		return fmt.Errorf("cannot parse URI: port %q is out of range", port)
+	}
+
+	return nil
+}
+
+// Scheme returns the URI scheme.
+func (u *URI) Scheme() string {
+	return u.scheme
+}
+
+// Host returns the URI host.
+func (u *URI) Host() string {
+	return u.host
+}
+
+// Hostname returns the host, stripped of an IPv6 zone identifier, matching
+// the net/url.URL.Hostname() contract.
+func (u *URI) Hostname() string {
+	if i := strings.IndexByte(u.host, '%'); i != -1 {
+		return u.host[:i]
+	}
+
+	return u.host
+}
+
+// Port returns the URI port.
+func (u *URI) Port() string {
+	return u.port
+}
+
+// User returns the username, if any.
+func (u *URI) User() string {
+	return u.user
+}
+
+// Password returns the password, if any.
+func (u *URI) Password() string {
+	return u.password
+}
+
+// Socket returns the unix socket path, if any.
+func (u *URI) Socket() string {
+	return u.socket
+}
+
+// Endpoints returns every host+port pair parsed out of a comma-separated host
+// list. A URI without a list still has a single entry, matching Host()/Port();
+// a unix socket URI has no entries (nil).
+func (u *URI) Endpoints() []Endpoint {
+	if len(u.endpoints) > 0 {
+		return u.endpoints
+	}
+
+	if u.socket != "" {
+		return nil
+	}
+
+	return []Endpoint{{Host: u.host, Port: u.port}}
+}
+
+// Query returns the parsed query parameters carried by the URI, if any.
+func (u *URI) Query() url.Values {
+	return u.query
+}
+
+// RawQuery returns the undecoded query string, without the leading "?".
+func (u *URI) RawQuery() string {
+	return u.rawQuery
+}
+
+// Fragment returns the fragment, without the leading "#".
+func (u *URI) Fragment() string {
+	return u.fragment
+}
+
+// TLSParams is the typed form of the TLS-related query parameters a URI may
+// carry, ready for a plugin to turn into a *tls.Config.
+type TLSParams struct {
+	// SSLMode is the raw "sslmode" value (e.g. "disable", "require", "verify-full"), if any.
+	SSLMode            string
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	ServerName         string
+}
+
+// secureSSLModes are the "sslmode" values that imply an encrypted connection.
+var secureSSLModes = map[string]bool{"require": true, "verify-ca": true, "verify-full": true}
+
+// TLSConfig extracts sslmode, tls, tlsCAFile, tlsCertFile, tlsKeyFile,
+// tlsInsecureSkipVerify and tlsServerName from the URI query.
+func (u *URI) TLSConfig() (*TLSParams, error) {
+	q := u.Query()
+
+	params := &TLSParams{
+		SSLMode:    q.Get("sslmode"),
+		CAFile:     q.Get("tlsCAFile"),
+		CertFile:   q.Get("tlsCertFile"),
+		KeyFile:    q.Get("tlsKeyFile"),
+		ServerName: q.Get("tlsServerName"),
+	}
+
+	var err error
+
+	if params.Enabled, err = parseOptionalBool(q.Get("tls")); err != nil {
+		return nil, fmt.Errorf("cannot parse URI: invalid tls value %q", q.Get("tls"))
+	}
+
+	if params.InsecureSkipVerify, err = parseOptionalBool(q.Get("tlsInsecureSkipVerify")); err != nil {
+		return nil, fmt.Errorf("cannot parse URI: invalid tlsInsecureSkipVerify value %q", q.Get("tlsInsecureSkipVerify"))
+	}
+
+	return params, nil
+}
+
+func parseOptionalBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// tlsEnabled reports whether the connection described by u is encrypted,
+// either inherently (the scheme itself is TLS, e.g. https) or via tls/sslmode.
+func (u *URI) tlsEnabled() (bool, error) {
+	if u.scheme == "https" {
+		return true, nil
+	}
+
+	params, err := u.TLSConfig()
+	if err != nil {
+		return false, err
+	}
+
+	return params.Enabled || secureSSLModes[params.SSLMode], nil
+}
+
+// Addr returns socket if it is set, otherwise host:port (or just host, if port
+// is not set). For a comma-separated host list, Addr only ever reflects the
+// first endpoint; use Endpoints to see the rest.
+func (u *URI) Addr() string {
+	if u.socket != "" {
+		return u.socket
+	}
+
+	if u.port != "" {
+		return net.JoinHostPort(u.host, u.port)
+	}
+
+	return u.host
+}
+
+// String reassembles the URI into a valid URI string, re-encoding reserved
+// characters in user/password/resource the same way net/url.URL.String() does.
+// For a comma-separated host list, String only reflects the first endpoint
+// (matching Host()/Port()/Addr()); callers persisting or logging a validated
+// multi-host URI via String() will silently lose the rest of the failover list.
+func (u *URI) String() string {
+	out := &url.URL{Scheme: u.scheme, RawQuery: u.rawQuery, Fragment: u.fragment}
+
+	if u.socket != "" {
+		out.Path = u.socket
+
+		return out.String()
+	}
+
+	if u.user != "" {
+		if u.password != "" {
+			out.User = url.UserPassword(u.user, u.password)
+		} else {
+			out.User = url.User(u.user)
+		}
+	}
+
+	host := u.host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	if u.port != "" {
+		host = host + ":" + u.port
+	}
+
+	out.Host = host
+
+	if u.resource != "" {
+		out.Path = "/" + u.resource
+	}
+
+	return out.String()
+}
+
+// URIValidator is used by the framework to validate a "uri" typed configuration parameter.
+type URIValidator struct {
+	Defaults       *Defaults
+	AllowedSchemes []string
+	// AllowedParams, when non-empty, restricts the query keys a URI may carry.
+	AllowedParams []string
+	// AllowMultipleHosts allows a comma-separated host list. When false (the
+	// default), a URI with more than one Endpoint is rejected.
+	AllowMultipleHosts bool
+	// RequireTLS rejects a URI that is not encrypted, neither inherently (e.g.
+	// https) nor via a "tls=true"/"sslmode=require" (or stricter) query parameter.
+	RequireTLS bool
+}
+
+// Validate parses *value and checks it against the scheme and query-parameter restrictions.
+func (v URIValidator) Validate(value *string) error {
+	if value == nil {
+		return nil
+	}
+
+	u, err := New(*value, v.Defaults)
+	if err != nil {
+		return err
+	}
+
+	if !v.AllowMultipleHosts && len(u.endpoints) > 1 {
+		return fmt.Errorf("URI must not specify more than one host")
+	}
+
+	if v.RequireTLS {
+		enabled, err := u.tlsEnabled()
+		if err != nil {
+			return err
+		}
+
+		if !enabled {
+			return fmt.Errorf("URI scheme %q requires an encrypted connection", u.scheme)
+		}
+	}
+
+	if len(v.AllowedSchemes) > 0 {
+		allowed := false
+
+		for _, scheme := range v.AllowedSchemes {
+			if scheme == u.scheme {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Errorf("URI scheme %q is not allowed", u.scheme)
+		}
+	}
+
+	if len(v.AllowedParams) > 0 {
+		for key := range u.query {
+			allowed := false
+
+			for _, param := range v.AllowedParams {
+				if param == key {
+					allowed = true
+					break
+				}
+			}
+
+			if !allowed {
+				return fmt.Errorf("URI parameter %q is not allowed", key)
+			}
+		}
+	}
+
+	return nil
+}
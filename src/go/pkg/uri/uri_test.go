@@ -1,6 +1,8 @@
 package uri
 
 import (
+	"fmt"
+	"net/url"
 	"reflect"
 	"testing"
 )
@@ -49,6 +51,25 @@ func TestURI_Addr(t *testing.T) {
 	}
 }
 
+func TestURI_Hostname(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"Should return host as is", "127.0.0.1", "127.0.0.1"},
+		{"Should strip IPv6 zone", "fe80::1%en0", "fe80::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &URI{host: tt.host}
+			if got := u.Hostname(); got != tt.want {
+				t.Errorf("Hostname() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestURI_String(t *testing.T) {
 	type fields struct {
 		scheme   string
@@ -95,6 +116,16 @@ func TestURI_String(t *testing.T) {
 			fields{scheme: "unix", socket: "/var/lib/mysql/mysql.sock"},
 			"unix:///var/lib/mysql/mysql.sock",
 		},
+		{
+			"Should escape reserved characters in user",
+			fields{scheme: "ftp", host: "127.0.0.1", user: "john doe"},
+			"ftp://john%20doe@127.0.0.1",
+		},
+		{
+			"Should escape reserved characters in resource",
+			fields{scheme: "oracle", host: "127.0.0.1", port: "1521", resource: "my db"},
+			"oracle://127.0.0.1:1521/my%20db",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -114,6 +145,19 @@ func TestURI_String(t *testing.T) {
 	}
 }
 
+func TestURI_String_RoundTripsPercentEncodedResource(t *testing.T) {
+	want := "oracle://localhost:1521/my%20db"
+
+	u, err := New(want, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := u.String(); got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+}
+
 var (
 	defaults              = &Defaults{Scheme: "https", Port: "443"}
 	defaultsWithoutPort   = &Defaults{Scheme: "https"}
@@ -160,7 +204,7 @@ func TestNew(t *testing.T) {
 		{
 			"Parse URI without scheme and port, defaults are partly set (only scheme)",
 			args{"localhost", defaultsWithoutPort},
-			&URI{scheme: "https", host: "localhost"},
+			&URI{scheme: "https", host: "localhost", port: "443"},
 			false,
 		},
 		{
@@ -262,7 +306,7 @@ func TestNew(t *testing.T) {
 		{
 			"Parse URI with ipv6 address. Test 5",
 			args{"https://[::1]", nil},
-			&URI{scheme: "https", host: "::1"},
+			&URI{scheme: "https", host: "::1", port: "443"},
 			false,
 		},
 		{
@@ -277,6 +321,40 @@ func TestNew(t *testing.T) {
 			&URI{scheme: "tcp", host: "::1", port: "11289"},
 			false,
 		},
+		{
+			"Parse URI with query string",
+			args{"postgres://localhost:5432/mydb?sslmode=require&connect_timeout=5", nil},
+			&URI{
+				scheme: "postgres", host: "localhost", port: "5432", resource: "mydb",
+				rawQuery: "sslmode=require&connect_timeout=5",
+				query:    url.Values{"sslmode": []string{"require"}, "connect_timeout": []string{"5"}},
+			},
+			false,
+		},
+		{
+			"Parse URI with fragment",
+			args{"https://localhost/docs#section-2", nil},
+			&URI{scheme: "https", host: "localhost", port: "443", resource: "docs", fragment: "section-2"},
+			false,
+		},
+		{
+			"Must fail if query string is malformed",
+			args{"postgres://localhost/mydb?%zz", nil},
+			nil,
+			true,
+		},
+		{
+			"Parse URI with percent-escaped userinfo",
+			args{"ftp://john%20doe@host", nil},
+			&URI{scheme: "ftp", host: "host", user: "john doe"},
+			false,
+		},
+		{
+			"Parse URI with non-ASCII hostname converted to punycode",
+			args{"https://bücher.example", nil},
+			&URI{scheme: "https", host: "xn--bcher-kva.example", port: "443"},
+			false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -296,12 +374,15 @@ var (
 	uri              = "ssh://localhost:22"
 	uriWithoutScheme = "localhost:22"
 	uriOnlyHost      = "localhost"
+	uriWithParam     = "postgres://localhost:5432/mydb?sslmode=require"
+	uriWithBadParam  = "postgres://localhost:5432/mydb?ssl=require"
 )
 
 func TestURIValidator_Validate(t *testing.T) {
 	type fields struct {
 		Defaults       *Defaults
 		AllowedSchemes []string
+		AllowedParams  []string
 	}
 	type args struct {
 		value *string
@@ -314,34 +395,47 @@ func TestURIValidator_Validate(t *testing.T) {
 	}{
 		{
 			"Validate uri with scheme in specified range",
-			fields{nil, []string{"ssh"}},
+			fields{nil, []string{"ssh"}, nil},
 			args{&uri},
 			false,
 		},
 		{
 			"Validate uri, scheme is not limited",
-			fields{nil, nil},
+			fields{nil, nil, nil},
 			args{&uriWithoutScheme},
 			false,
 		},
 		{
 			"Must fail if scheme is out of range",
-			fields{nil, []string{"ssh"}},
+			fields{nil, []string{"ssh"}, nil},
 			args{&uriWithoutScheme},
 			true,
 		},
 		{
 			"Must fail if default scheme is out of range",
-			fields{defaults, []string{"ssh"}},
+			fields{defaults, []string{"ssh"}, nil},
 			args{&uriOnlyHost},
 			true,
 		},
+		{
+			"Validate uri with allowed query parameter",
+			fields{nil, nil, []string{"sslmode"}},
+			args{&uriWithParam},
+			false,
+		},
+		{
+			"Must fail if query parameter is not allowed",
+			fields{nil, nil, []string{"sslmode"}},
+			args{&uriWithBadParam},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			v := URIValidator{
 				Defaults:       tt.fields.Defaults,
 				AllowedSchemes: tt.fields.AllowedSchemes,
+				AllowedParams:  tt.fields.AllowedParams,
 			}
 			if err := v.Validate(tt.args.value); (err != nil) != tt.wantErr {
 				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
@@ -349,3 +443,247 @@ func TestURIValidator_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestNew_RegisteredSchemes(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawuri   string
+		wantPort string
+		wantErr  bool
+	}{
+		{"http uses its default port", "http://example.com", "80", false},
+		{"https uses its default port", "https://example.com", "443", false},
+		{"tcp has no default port", "tcp://example.com", "", false},
+		{"oracle uses its default port", "oracle://example.com/XE", "1521", false},
+		{"postgres uses its default port", "postgres://example.com/mydb", "5432", false},
+		{"postgres accepts a unix socket", "postgres:///var/run/postgresql/.s.PGSQL.5432", "", false},
+		{"mysql uses its default port", "mysql://example.com/mydb", "3306", false},
+		{"mongodb uses its default port", "mongodb://example.com/mydb", "27017", false},
+		{"redis uses its default port", "redis://example.com", "6379", false},
+		{"memcached uses its default port", "memcached://example.com", "11211", false},
+		{"redis+sentinel uses its default port", "redis+sentinel://example.com", "26379", false},
+		{"oracle rejects a nested resource", "oracle://example.com/XE/extra", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := New(tt.rawuri, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && u.Port() != tt.wantPort {
+				t.Errorf("Port() = %v, want %v", u.Port(), tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestRegisterScheme(t *testing.T) {
+	RegisterScheme("zbxtest", SchemeSpec{
+		DefaultPort:  "12345",
+		RequiresHost: true,
+		PathStyle:    PathStyleNone,
+		Validate: func(u *URI) error {
+			if u.Host() == "forbidden" {
+				return fmt.Errorf("host %q is not allowed", u.Host())
+			}
+
+			return nil
+		},
+	})
+
+	u, err := New("zbxtest://example.com", nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if u.Port() != "12345" {
+		t.Errorf("Port() = %v, want 12345", u.Port())
+	}
+
+	if _, err := New("zbxtest://example.com/resource", nil); err == nil {
+		t.Error("New() expected error for a scheme that does not accept a resource")
+	}
+
+	if _, err := New("zbxtest://forbidden", nil); err == nil {
+		t.Error("New() expected error from the scheme's Validate hook")
+	}
+}
+
+func TestNew_Endpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		want    []Endpoint
+		wantErr bool
+	}{
+		{
+			"Single host has one endpoint matching Host()/Port()",
+			"tcp://localhost:80",
+			[]Endpoint{{Host: "localhost", Port: "80"}},
+			false,
+		},
+		{
+			"Parse comma-separated IPv4 host list",
+			"mongodb://h1:27017,h2:27017,h3:27017/?replicaSet=rs0",
+			[]Endpoint{{Host: "h1", Port: "27017"}, {Host: "h2", Port: "27017"}, {Host: "h3", Port: "27017"}},
+			false,
+		},
+		{
+			"Parse mixed IPv4/IPv6/bracketed host list",
+			"mongodb://127.0.0.1:27017,[::1]:27018,[fe80::1%25en0]",
+			[]Endpoint{
+				{Host: "127.0.0.1", Port: "27017"},
+				{Host: "::1", Port: "27018"},
+				{Host: "fe80::1%en0", Port: "27017"},
+			},
+			false,
+		},
+		{
+			"mongodb+srv host list has no ports",
+			"mongodb+srv://h1,h2,h3/mydb",
+			[]Endpoint{{Host: "h1"}, {Host: "h2"}, {Host: "h3"}},
+			false,
+		},
+		{
+			"Parse redis+sentinel host list",
+			"redis+sentinel://h1:26379,h2:26379,h3:26379",
+			[]Endpoint{{Host: "h1", Port: "26379"}, {Host: "h2", Port: "26379"}, {Host: "h3", Port: "26379"}},
+			false,
+		},
+		{
+			"mongodb+srv must fail if a port is given",
+			"mongodb+srv://h1:27017/mydb",
+			nil,
+			true,
+		},
+		{
+			"Must fail if a host list entry is empty",
+			"mongodb://h1:27017,,h3:27017/mydb",
+			nil,
+			true,
+		},
+		{
+			"Must fail if a host list has a trailing comma",
+			"mongodb://h1:27017,/mydb",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := New(tt.rawuri, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(u.Endpoints(), tt.want) {
+				t.Errorf("Endpoints() = %+v, want %+v", u.Endpoints(), tt.want)
+			}
+		})
+	}
+}
+
+func TestURI_String_TruncatesMultiHost(t *testing.T) {
+	u, err := New("mongodb://h1:27017,h2:27017,h3:27017/mydb", nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	want := "mongodb://h1:27017/mydb"
+	if got := u.String(); got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+
+	if got := u.Addr(); got != "h1:27017" {
+		t.Errorf("Addr() = %v, want %v", got, "h1:27017")
+	}
+}
+
+func TestURIValidator_Validate_AllowMultipleHosts(t *testing.T) {
+	list := "h1:27017,h2:27017"
+
+	v := URIValidator{}
+	if err := v.Validate(&list); err == nil {
+		t.Error("Validate() expected error when multiple hosts are not allowed")
+	}
+
+	v = URIValidator{AllowMultipleHosts: true}
+	if err := v.Validate(&list); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+}
+
+func TestURI_TLSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		want    *TLSParams
+		wantErr bool
+	}{
+		{
+			"No TLS parameters",
+			"postgres://localhost/mydb",
+			&TLSParams{},
+			false,
+		},
+		{
+			"Full set of TLS parameters",
+			"postgres://localhost/mydb?sslmode=verify-full&tls=true&tlsCAFile=ca.pem" +
+				"&tlsCertFile=cert.pem&tlsKeyFile=key.pem&tlsInsecureSkipVerify=true&tlsServerName=db.example.com",
+			&TLSParams{
+				SSLMode: "verify-full", Enabled: true, CAFile: "ca.pem", CertFile: "cert.pem",
+				KeyFile: "key.pem", InsecureSkipVerify: true, ServerName: "db.example.com",
+			},
+			false,
+		},
+		{
+			"Must fail if tls value is not a boolean",
+			"postgres://localhost/mydb?tls=maybe",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := New(tt.rawuri, nil)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			got, err := u.TLSConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TLSConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURIValidator_Validate_RequireTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		wantErr bool
+	}{
+		{"https is inherently encrypted", "https://localhost", false},
+		{"plain http must fail", "http://localhost", true},
+		{"tcp with tls=true passes", "tcp://localhost?tls=true", false},
+		{"plain redis must fail", "redis://localhost", true},
+		{"mongodb with tls=true passes", "mongodb://localhost?tls=true", false},
+		{"postgres with sslmode=require passes", "postgres://localhost/mydb?sslmode=require", false},
+		{"postgres with sslmode=disable must fail", "postgres://localhost/mydb?sslmode=disable", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := URIValidator{RequireTLS: true}
+			value := tt.rawuri
+
+			if err := v.Validate(&value); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}